@@ -3,14 +3,17 @@ package main
 import (
 	"fmt"
 	"io/ioutil"
+	"net"
+	"net/http"
 	"os"
 	"os/exec"
 	"path"
 	"path/filepath"
 	"text/template"
+	"time"
 )
 
-var CchContainerName = "carbon-clickhouse-gch-test"
+var cchInstanceSeq int
 
 type CarbonClickhouse struct {
 	Version string `toml:"version"`
@@ -22,6 +25,10 @@ type CarbonClickhouse struct {
 
 	TZ string `toml:"tz"` // override timezone
 
+	Env          map[string]string      `toml:"env"`     // extra container env vars, passed through as -e
+	ExtraVolumes []string               `toml:"volumes"` // extra "host:container[:ro]" bind mounts
+	Params       map[string]interface{} `toml:"params"`  // extra values merged into the config template context
+
 	address   string `toml:"-"`
 	container string `toml:"-"`
 	storeDir  string `toml:"-"`
@@ -43,7 +50,8 @@ func (c *CarbonClickhouse) Start(testDir, clickhouseURL, clickhouseContainer str
 		return err, ""
 	}
 
-	c.container = CchContainerName
+	cchInstanceSeq++
+	c.container = fmt.Sprintf("carbon-clickhouse-gch-test-%d", cchInstanceSeq)
 
 	c.storeDir, err = ioutil.TempDir("", "carbon-clickhouse")
 	if err != nil {
@@ -63,12 +71,13 @@ func (c *CarbonClickhouse) Start(testDir, clickhouseURL, clickhouseContainer str
 		c.Cleanup()
 		return err, ""
 	}
-	param := struct {
-		CLICKHOUSE_URL string
-		CCH_ADDR       string
-	}{
-		CLICKHOUSE_URL: clickhouseURL,
-		CCH_ADDR:       c.address,
+
+	param := map[string]interface{}{
+		"CLICKHOUSE_URL": clickhouseURL,
+		"CCH_ADDR":       c.address,
+	}
+	for k, v := range c.Params {
+		param[k] = v
 	}
 
 	configFile := path.Join(c.storeDir, "carbon-clickhouse.conf")
@@ -94,6 +103,12 @@ func (c *CarbonClickhouse) Start(testDir, clickhouseURL, clickhouseContainer str
 	if c.TZ != "" {
 		cchStart = append(cchStart, "-e", "TZ="+c.TZ)
 	}
+	for k, v := range c.Env {
+		cchStart = append(cchStart, "-e", k+"="+v)
+	}
+	for _, v := range c.ExtraVolumes {
+		cchStart = append(cchStart, "-v", v)
+	}
 
 	cchStart = append(cchStart, c.DockerImage+":"+c.Version)
 
@@ -152,3 +167,56 @@ func (c *CarbonClickhouse) Address() string {
 func (c *CarbonClickhouse) Container() string {
 	return c.container
 }
+
+// WaitReady polls the carbon protocol TCP port and the /debug/vars endpoint
+// until both answer or timeout elapses, so tests can assert on upload
+// behavior right after Start instead of sleeping a fixed duration.
+func (c *CarbonClickhouse) WaitReady(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+
+	for time.Now().Before(deadline) {
+		conn, err := net.DialTimeout("tcp", c.address, time.Second)
+		if err == nil {
+			conn.Close()
+
+			resp, err := http.Get("http://" + c.debugVarsAddr() + "/debug/vars")
+			if err == nil {
+				resp.Body.Close()
+				if resp.StatusCode == http.StatusOK {
+					return nil
+				}
+			}
+		}
+
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	return fmt.Errorf("carbon-clickhouse %s not ready after %s", c.container, timeout)
+}
+
+// debugVarsAddr assumes carbon-clickhouse exposes its expvar/pprof endpoint
+// on the same host as the carbon protocol port, one port up.
+func (c *CarbonClickhouse) debugVarsAddr() string {
+	host, port, err := net.SplitHostPort(c.address)
+	if err != nil {
+		return c.address
+	}
+
+	p := 0
+	fmt.Sscanf(port, "%d", &p)
+
+	return fmt.Sprintf("%s:%d", host, p+1)
+}
+
+// Logs returns the container's combined stdout/stderr via `docker logs`, so
+// integration tests can assert on upload behavior instead of sleeping.
+func (c *CarbonClickhouse) Logs() (error, string) {
+	if len(c.container) == 0 {
+		return nil, ""
+	}
+
+	cmd := exec.Command(c.Docker, "logs", c.container)
+	out, err := cmd.CombinedOutput()
+
+	return err, string(out)
+}
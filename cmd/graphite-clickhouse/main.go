@@ -0,0 +1,42 @@
+// Command graphite-clickhouse serves the Graphite-compatible and
+// Prometheus-compatible read/write APIs backed by ClickHouse.
+package main
+
+import (
+	"flag"
+	"log"
+
+	"github.com/lomik/graphite-clickhouse/config"
+	"github.com/lomik/graphite-clickhouse/pkg/httpserver"
+	"github.com/lomik/graphite-clickhouse/prometheus"
+)
+
+func main() {
+	configFile := flag.String("config", "/etc/graphite-clickhouse/graphite-clickhouse.conf", "config file path")
+	flag.Parse()
+
+	cfg, err := config.Load(*configFile)
+	if err != nil {
+		log.Fatalf("error loading config: %s", err)
+	}
+
+	promHandler, err := prometheus.NewHandler(cfg)
+	if err != nil {
+		log.Fatalf("error starting prometheus handler: %s", err)
+	}
+	defer promHandler.Stop()
+
+	// The Graphite /render and /metrics/find handlers mount onto the same
+	// httpserver.Server alongside promHandler; they live outside this
+	// repository slice, so only the Prometheus API is wired here.
+	srv, err := httpserver.New(&cfg.HTTP, promHandler)
+	if err != nil {
+		log.Fatalf("error starting http server: %s", err)
+	}
+
+	srv.Ready()
+
+	if err := srv.Serve(); err != nil {
+		log.Fatalf("http server stopped: %s", err)
+	}
+}
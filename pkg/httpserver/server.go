@@ -0,0 +1,316 @@
+// Package httpserver owns the single listening socket graphite-clickhouse
+// serves everything on: the Graphite render/find handlers, the Prometheus
+// API, and (eventually) a gRPC remote-read service, multiplexed behind
+// cmux. It is modeled on Prometheus' web/web.go Options/Handler split, but
+// trimmed to what graphite-clickhouse actually needs.
+package httpserver
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/mwitkow/go-conntrack"
+	opentracing_nethttp "github.com/opentracing-contrib/go-stdlib/nethttp"
+	"github.com/opentracing/opentracing-go"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/soheilhy/cmux"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/lomik/graphite-clickhouse/config"
+	"github.com/lomik/zapwriter"
+)
+
+var logger = zapwriter.Logger("httpserver").Sugar()
+
+// Server owns the listening socket, TLS state and graceful shutdown for the
+// HTTP(S) endpoints exposed by graphite-clickhouse.
+type Server struct {
+	config *config.HTTP
+
+	listener net.Listener
+	mux      cmux.CMux
+	http     *http.Server
+
+	ready    int32 // atomic, set once the server is accepting real traffic
+	quitCh   chan struct{}
+	quitOnce sync.Once
+
+	mu      sync.Mutex
+	tlsCert *tls.Certificate
+}
+
+// New builds a Server around handler, wrapping it with BasicAuth,
+// per-route instrumentation, OpenTracing and (if enabled) pprof, but does
+// not start listening yet -- call Serve for that.
+func New(cfg *config.HTTP, handler http.Handler) (*Server, error) {
+	s := &Server{
+		config: cfg,
+		quitCh: make(chan struct{}),
+	}
+
+	if cfg.TLSCertFile != "" {
+		if err := s.loadCertificate(); err != nil {
+			return nil, errors.Wrap(err, "error loading TLS certificate")
+		}
+		s.watchSIGHUP()
+	}
+
+	s.watchShutdownSignals()
+
+	mux := http.NewServeMux()
+	mux.Handle("/", s.instrument("root", s.basicAuth(handler)))
+	mux.HandleFunc("/-/ready", s.handleReady)
+	// /-/quit and pprof can both be used to degrade or inspect a live
+	// process (shutdown, heap dump), so -- like Prometheus' own
+	// --web.enable-lifecycle-gated routes -- they go through the same
+	// basicAuth as the main handler instead of being registered in the clear.
+	mux.Handle("/-/quit", s.basicAuth(http.HandlerFunc(s.handleQuit)))
+	mux.Handle("/metrics", promhttp.Handler())
+
+	if cfg.PprofEnabled {
+		mux.Handle("/debug/pprof/", s.basicAuth(http.HandlerFunc(pprof.Index)))
+		mux.Handle("/debug/pprof/cmdline", s.basicAuth(http.HandlerFunc(pprof.Cmdline)))
+		mux.Handle("/debug/pprof/profile", s.basicAuth(http.HandlerFunc(pprof.Profile)))
+		mux.Handle("/debug/pprof/symbol", s.basicAuth(http.HandlerFunc(pprof.Symbol)))
+		mux.Handle("/debug/pprof/trace", s.basicAuth(http.HandlerFunc(pprof.Trace)))
+	}
+
+	s.http = &http.Server{
+		Handler:      opentracing_nethttp.Middleware(opentracing.GlobalTracer(), mux),
+		ReadTimeout:  cfg.ReadTimeout,
+		WriteTimeout: cfg.WriteTimeout,
+	}
+
+	return s, nil
+}
+
+// instrument wraps a handler with per-route request count/duration metrics,
+// as Prometheus' own web handlers do via promhttp.InstrumentHandler*.
+func (s *Server) instrument(route string, next http.Handler) http.Handler {
+	reqCounter := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name:        "graphite_clickhouse_http_requests_total",
+		Help:        "Total number of HTTP requests, by route and status code.",
+		ConstLabels: prometheus.Labels{"route": route},
+	}, []string{"code"})
+	reqDuration := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:        "graphite_clickhouse_http_request_duration_seconds",
+		Help:        "HTTP request latency, by route.",
+		ConstLabels: prometheus.Labels{"route": route},
+	}, []string{"code"})
+
+	prometheus.Register(reqCounter)
+	prometheus.Register(reqDuration)
+
+	return promhttp.InstrumentHandlerDuration(reqDuration,
+		promhttp.InstrumentHandlerCounter(reqCounter, next))
+}
+
+// basicAuth enforces config.HTTP.BasicAuthUsers (bcrypt-hashed passwords)
+// when at least one user is configured; it is a no-op otherwise.
+func (s *Server) basicAuth(next http.Handler) http.Handler {
+	if len(s.config.BasicAuthUsers) == 0 {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		hash, known := s.config.BasicAuthUsers[user]
+		if !ok || !known || bcrypt.CompareHashAndPassword([]byte(hash), []byte(pass)) != nil {
+			w.Header().Set("WWW-Authenticate", `Basic realm="graphite-clickhouse"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) handleReady(w http.ResponseWriter, r *http.Request) {
+	if atomic.LoadInt32(&s.ready) == 0 {
+		http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("OK"))
+}
+
+func (s *Server) handleQuit(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("OK"))
+	s.closeQuitCh()
+}
+
+// closeQuitCh closes quitCh exactly once, since it can be triggered by
+// /-/quit and by a shutdown signal, and possibly more than once from either
+// source (a double /-/quit, or SIGTERM followed by SIGINT).
+func (s *Server) closeQuitCh() {
+	s.quitOnce.Do(func() { close(s.quitCh) })
+}
+
+// Ready marks the server as able to serve real traffic; until called,
+// /-/ready returns 503 so a load balancer won't route to it.
+func (s *Server) Ready() {
+	atomic.StoreInt32(&s.ready, 1)
+}
+
+// Quit returns a channel that is closed when /-/quit is hit or a shutdown
+// signal is received, so callers can block on it in their main goroutine.
+func (s *Server) Quit() <-chan struct{} {
+	return s.quitCh
+}
+
+func (s *Server) loadCertificate() error {
+	cert, err := tls.LoadX509KeyPair(s.config.TLSCertFile, s.config.TLSKeyFile)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.tlsCert = &cert
+	s.mu.Unlock()
+	return nil
+}
+
+// watchSIGHUP reloads the TLS certificate/key pair on SIGHUP without
+// dropping the listener, so operators can rotate certs without a restart.
+func (s *Server) watchSIGHUP() {
+	sighup := make(chan os.Signal, 1)
+	signal.Notify(sighup, syscall.SIGHUP)
+
+	go func() {
+		for range sighup {
+			if err := s.loadCertificate(); err != nil {
+				logger.Errorf("error reloading TLS certificate: %s", err)
+			} else {
+				logger.Infof("TLS certificate reloaded")
+			}
+		}
+	}()
+}
+
+// watchShutdownSignals closes quitCh on SIGTERM or SIGINT, so Serve's
+// select on s.quitCh takes the same graceful shutdown path whether it was
+// triggered by an operator signal or by /-/quit.
+func (s *Server) watchShutdownSignals() {
+	sigterm := make(chan os.Signal, 1)
+	signal.Notify(sigterm, syscall.SIGTERM, syscall.SIGINT)
+
+	go func() {
+		<-sigterm
+		s.closeQuitCh()
+	}()
+}
+
+func (s *Server) tlsConfig() *tls.Config {
+	cfg := &tls.Config{
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			return s.tlsCert, nil
+		},
+	}
+
+	if s.config.ClientCAFile != "" {
+		pool, err := loadCertPool(s.config.ClientCAFile)
+		if err != nil {
+			logger.Errorf("error loading client CA file, mTLS disabled: %s", err)
+		} else {
+			cfg.ClientCAs = pool
+			cfg.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+
+	return cfg
+}
+
+// Serve opens the listening socket, multiplexing HTTP and (reserved for a
+// future remote-read service) gRPC on the same port via cmux, and blocks
+// until the server is shut down.
+func (s *Server) Serve() error {
+	rawListener, err := net.Listen("tcp", s.config.Listen)
+	if err != nil {
+		return errors.Wrap(err, "error starting listener")
+	}
+
+	if s.config.MaxConnections > 0 {
+		rawListener = conntrack.NewListener(rawListener,
+			conntrack.TrackWithName("graphite-clickhouse"),
+			conntrack.TrackWithMaxConnections(s.config.MaxConnections),
+		)
+	}
+
+	if s.tlsCert != nil {
+		rawListener = tls.NewListener(rawListener, s.tlsConfig())
+	}
+
+	s.listener = rawListener
+	s.mux = cmux.New(rawListener)
+
+	httpListener := s.mux.Match(cmux.HTTP1Fast())
+	grpcListener := s.mux.Match(cmux.HTTP2HeaderField("content-type", "application/grpc"))
+
+	errCh := make(chan error, 1)
+	go func() { errCh <- s.http.Serve(httpListener) }()
+	go func() {
+		// no grpc.Server is registered yet -- see chunk0-5 for remote-write;
+		// remote-read over gRPC is reserved future work, so just drain.
+		for {
+			conn, err := grpcListener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	go func() { errCh <- s.mux.Serve() }()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-s.quitCh:
+		return s.shutdown()
+	}
+}
+
+// shutdown stops accepting new HTTP connections and then waits up to
+// config.HTTP.ShutdownGrace (10s when unset) for in-flight requests to
+// finish, so a SIGTERM during a deploy doesn't cut off in-flight Grafana
+// dashboards.
+func (s *Server) shutdown() error {
+	grace := s.config.ShutdownGrace
+	if grace <= 0 {
+		grace = 10 * time.Second
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), grace)
+	defer cancel()
+
+	return s.http.Shutdown(ctx)
+}
+
+func loadCertPool(file string) (*x509.CertPool, error) {
+	pem, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, errors.Errorf("no certificates found in %s", file)
+	}
+
+	return pool, nil
+}
@@ -0,0 +1,83 @@
+package httpserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/lomik/graphite-clickhouse/config"
+)
+
+func TestBasicAuth(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.DefaultCost)
+	if err != nil {
+		t.Fatalf("error hashing password: %s", err)
+	}
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	cases := []struct {
+		name       string
+		users      map[string]string
+		user, pass string
+		setAuth    bool
+		wantStatus int
+	}{
+		{
+			name:       "no users configured is a no-op",
+			users:      nil,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "missing credentials",
+			users:      map[string]string{"alice": string(hash)},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "unknown user",
+			users:      map[string]string{"alice": string(hash)},
+			user:       "bob",
+			pass:       "secret",
+			setAuth:    true,
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "wrong password",
+			users:      map[string]string{"alice": string(hash)},
+			user:       "alice",
+			pass:       "wrong",
+			setAuth:    true,
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "correct credentials",
+			users:      map[string]string{"alice": string(hash)},
+			user:       "alice",
+			pass:       "secret",
+			setAuth:    true,
+			wantStatus: http.StatusOK,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			s := &Server{config: &config.HTTP{BasicAuthUsers: c.users}}
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if c.setAuth {
+				req.SetBasicAuth(c.user, c.pass)
+			}
+			rr := httptest.NewRecorder()
+
+			s.basicAuth(ok).ServeHTTP(rr, req)
+
+			if rr.Code != c.wantStatus {
+				t.Errorf("status = %d, want %d", rr.Code, c.wantStatus)
+			}
+		})
+	}
+}
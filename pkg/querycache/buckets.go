@@ -0,0 +1,56 @@
+package querycache
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// Bucket is one aligned step-sized slice of a requested [from, until)
+// range. Splitting a request into buckets lets a partially-overlapping
+// range reuse whatever buckets are already cached and only fetch the tail.
+type Bucket struct {
+	From  int64
+	Until int64
+}
+
+// SplitBuckets aligns [from, until) to step-sized boundaries and splits it
+// into a sequence of buckets, so that two requests differing only in their
+// tail (e.g. a dashboard auto-refreshing "last 1h") share every bucket but
+// the last.
+func SplitBuckets(from, until, step int64) []Bucket {
+	if step <= 0 || until <= from {
+		return []Bucket{{From: from, Until: until}}
+	}
+
+	alignedFrom := from - (from % step)
+
+	buckets := make([]Bucket, 0, (until-alignedFrom)/step+1)
+	for b := alignedFrom; b < until; b += step {
+		end := b + step
+		if end > until {
+			end = until
+		}
+		buckets = append(buckets, Bucket{From: b, Until: end})
+	}
+
+	return buckets
+}
+
+// matchGlob reports whether target matches a graphite-style glob pattern.
+// Pattern and target are split on '.' and each segment is matched with the
+// filepath glob matcher, so a '*' never crosses a '.' just as a filepath
+// '*' never crosses a '/'.
+func matchGlob(pattern, target string) (bool, error) {
+	patternParts := strings.Split(pattern, ".")
+	targetParts := strings.Split(target, ".")
+	if len(patternParts) != len(targetParts) {
+		return false, nil
+	}
+	for i := range patternParts {
+		ok, err := filepath.Match(patternParts[i], targetParts[i])
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+	return true, nil
+}
@@ -0,0 +1,71 @@
+package querycache
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitBuckets(t *testing.T) {
+	cases := []struct {
+		name              string
+		from, until, step int64
+		want              []Bucket
+	}{
+		{
+			name:  "zero step returns the whole range unsplit",
+			from:  100, until: 400, step: 0,
+			want: []Bucket{{From: 100, Until: 400}},
+		},
+		{
+			name:  "until not after from returns the range unsplit",
+			from:  400, until: 400, step: 60,
+			want: []Bucket{{From: 400, Until: 400}},
+		},
+		{
+			name:  "already aligned range splits evenly",
+			from:  0, until: 180, step: 60,
+			want: []Bucket{{From: 0, Until: 60}, {From: 60, Until: 120}, {From: 120, Until: 180}},
+		},
+		{
+			name:  "unaligned from is rounded down to the step boundary",
+			from:  70, until: 130, step: 60,
+			want: []Bucket{{From: 60, Until: 120}, {From: 120, Until: 130}},
+		},
+		{
+			name:  "last bucket is truncated to until",
+			from:  0, until: 90, step: 60,
+			want: []Bucket{{From: 0, Until: 60}, {From: 60, Until: 90}},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := SplitBuckets(c.from, c.until, c.step)
+			if !reflect.DeepEqual(got, c.want) {
+				t.Errorf("SplitBuckets(%d, %d, %d) = %v, want %v", c.from, c.until, c.step, got, c.want)
+			}
+		})
+	}
+}
+
+func TestMatchGlob(t *testing.T) {
+	cases := []struct {
+		pattern, target string
+		want            bool
+	}{
+		{"servers.*.cpu", "servers.web1.cpu", true},
+		{"servers.*.cpu", "servers.web1.mem", false},
+		{"servers.web1.cpu", "servers.web1.cpu", true},
+		{"servers.*.cpu", "servers.web1.sub.cpu", false},
+	}
+
+	for _, c := range cases {
+		got, err := matchGlob(c.pattern, c.target)
+		if err != nil {
+			t.Fatalf("matchGlob(%q, %q) error: %s", c.pattern, c.target, err)
+		}
+		if got != c.want {
+			t.Errorf("matchGlob(%q, %q) = %v, want %v", c.pattern, c.target, got, c.want)
+		}
+	}
+}
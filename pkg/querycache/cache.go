@@ -0,0 +1,177 @@
+// Package querycache sits in front of the Graphite render handler and
+// prometheus.Handler.Querier.Select, coalescing identical in-flight queries
+// and caching finished results so that repeated Grafana dashboard refreshes
+// don't each re-hit ClickHouse.
+package querycache
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/sync/singleflight"
+
+	"github.com/lomik/graphite-clickhouse/config"
+)
+
+var (
+	hitsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "graphite_clickhouse_querycache_hits_total",
+		Help: "Total number of query cache hits.",
+	})
+	missesTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "graphite_clickhouse_querycache_misses_total",
+		Help: "Total number of query cache misses.",
+	})
+	coalescedTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "graphite_clickhouse_querycache_coalesced_total",
+		Help: "Total number of concurrent requests coalesced onto an in-flight query.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(hitsTotal, missesTotal, coalescedTotal)
+}
+
+// Key identifies a single cacheable query: a normalized target evaluated
+// over one aligned step-sized bucket, for one tenant.
+type Key struct {
+	Target        string
+	Tenant        string
+	From          int64
+	Until         int64
+	MaxDataPoints int64
+	Step          int64
+}
+
+func (k Key) String() string {
+	return strings.Join([]string{
+		k.Tenant, k.Target,
+		strconv.FormatInt(k.From, 10), strconv.FormatInt(k.Until, 10),
+		strconv.FormatInt(k.MaxDataPoints, 10), strconv.FormatInt(k.Step, 10),
+	}, "\x1f")
+}
+
+// Backend stores finished, serialized query results. The in-memory LRU is
+// always present and is consulted first; a shared Backend such as
+// RedisBackend, when set, is consulted on a local miss, so a cache warmed by
+// one instance benefits the others.
+type Backend interface {
+	Get(key string) ([]byte, bool)
+	Set(key string, value []byte, ttl time.Duration)
+}
+
+// Cache coalesces concurrent identical queries via singleflight and caches
+// their result in a size-bounded LRU plus an optional shared Backend.
+type Cache struct {
+	config *config.Cache
+	local  *lru.Cache
+	shared Backend
+	group  singleflight.Group
+}
+
+// New builds a Cache from the [cache] config section. cfg.Size bounds the
+// in-memory LRU; shared may be nil when no Redis/memcached backend is
+// configured.
+func New(cfg *config.Cache, shared Backend) (*Cache, error) {
+	size := cfg.Size
+	if size <= 0 {
+		size = 1000
+	}
+
+	local, err := lru.New(size)
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating query cache LRU")
+	}
+
+	return &Cache{config: cfg, local: local, shared: shared}, nil
+}
+
+// Fetcher answers one Key by actually querying the finder/render (or
+// PromQL) path; it is only ever invoked once per Key across concurrent
+// callers, courtesy of singleflight.
+type Fetcher func() ([]byte, error)
+
+// cacheEntry is what's actually stored in the local LRU. The LRU itself
+// only evicts under capacity pressure, so entries carry their own
+// expiresAt and Fetch treats an expired entry as a miss -- otherwise the
+// still-accumulating "current" bucket of an auto-refreshing dashboard
+// would be served stale forever until evicted by size, defeating
+// config.Cache.DefaultTTL/TTLOverrides entirely.
+type cacheEntry struct {
+	value     []byte
+	expiresAt time.Time
+}
+
+func (e cacheEntry) expired() bool {
+	return time.Now().After(e.expiresAt)
+}
+
+// Fetch returns the cached result for key if present and not expired,
+// otherwise calls fetch (coalescing concurrent callers for the same key)
+// and caches the result for the key's TTL.
+func (c *Cache) Fetch(key Key, noCache bool, fetch Fetcher) ([]byte, error) {
+	k := key.String()
+	ttl := c.ttlFor(key.Target)
+
+	if !noCache {
+		if v, ok := c.local.Get(k); ok {
+			entry := v.(cacheEntry)
+			if !entry.expired() {
+				hitsTotal.Inc()
+				return entry.value, nil
+			}
+			c.local.Remove(k)
+		}
+		if c.shared != nil {
+			if v, ok := c.shared.Get(k); ok {
+				hitsTotal.Inc()
+				c.local.Add(k, cacheEntry{value: v, expiresAt: time.Now().Add(ttl)})
+				return v, nil
+			}
+		}
+	}
+
+	missesTotal.Inc()
+
+	v, err, shared := c.group.Do(k, func() (interface{}, error) {
+		return fetch()
+	})
+	if shared {
+		coalescedTotal.Inc()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	result := v.([]byte)
+	if !noCache {
+		c.local.Add(k, cacheEntry{value: result, expiresAt: time.Now().Add(ttl)})
+		if c.shared != nil {
+			c.shared.Set(k, result, ttl)
+		}
+	}
+
+	return result, nil
+}
+
+// ttlFor applies the first matching glob in config.Cache.TTLOverrides,
+// falling back to config.Cache.DefaultTTL.
+func (c *Cache) ttlFor(target string) time.Duration {
+	for _, o := range c.config.TTLOverrides {
+		if ok, _ := matchGlob(o.Glob, target); ok {
+			return time.Duration(o.TTL)
+		}
+	}
+	return time.Duration(c.config.DefaultTTL)
+}
+
+// NoCache reports whether the request opted out of caching via a
+// "Cache-Control: no-cache" header, per HTTP semantics.
+func NoCache(r *http.Request) bool {
+	return strings.Contains(strings.ToLower(r.Header.Get("Cache-Control")), "no-cache")
+}
@@ -0,0 +1,45 @@
+package querycache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lomik/graphite-clickhouse/config"
+)
+
+func TestKeyString(t *testing.T) {
+	a := Key{Target: "servers.web1.cpu", Tenant: "team-a", From: 100, Until: 200, Step: 60}
+	b := Key{Target: "servers.web1.cpu", Tenant: "team-b", From: 100, Until: 200, Step: 60}
+
+	if a.String() == b.String() {
+		t.Fatalf("keys differing only by tenant must not collide: %q == %q", a.String(), b.String())
+	}
+
+	c := Key{Target: "servers.web1.cpu", Tenant: "team-a", From: 100, Until: 200, Step: 60}
+	if a.String() != c.String() {
+		t.Fatalf("identical keys must produce the same string: %q != %q", a.String(), c.String())
+	}
+}
+
+func TestCacheTTLFor(t *testing.T) {
+	c := &Cache{config: &config.Cache{
+		DefaultTTL: config.Duration(30 * time.Second),
+		TTLOverrides: []config.TTLOverride{
+			{Glob: "servers.*.cpu", TTL: config.Duration(5 * time.Minute)},
+		},
+	}}
+
+	cases := []struct {
+		target string
+		want   time.Duration
+	}{
+		{"servers.web1.cpu", 5 * time.Minute},
+		{"servers.web1.mem", 30 * time.Second},
+	}
+
+	for _, tc := range cases {
+		if got := c.ttlFor(tc.target); got != tc.want {
+			t.Errorf("ttlFor(%q) = %s, want %s", tc.target, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package querycache
+
+import (
+	"bytes"
+	"net/http"
+	"strconv"
+)
+
+// Middleware wraps a Graphite render handler (or any handler answering one
+// target/from/until query per request) with the same cache used by the
+// Prometheus query path. It is exported here so the render package's HTTP
+// handler can opt in; it is not mounted by anything in this repository
+// slice, since the render package's own http.Handler isn't part of it.
+func Middleware(cache *Cache, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+
+		key := Key{
+			Target:        q.Get("target"),
+			Tenant:        r.Header.Get("X-Scope-OrgID"),
+			From:          parseInt64(q.Get("from")),
+			Until:         parseInt64(q.Get("until")),
+			MaxDataPoints: parseInt64(q.Get("maxDataPoints")),
+		}
+
+		body, err := cache.Fetch(key, NoCache(r), func() ([]byte, error) {
+			rec := newResponseRecorder()
+			next.ServeHTTP(rec, r)
+			return rec.body.Bytes(), rec.err
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("X-Graphite-Cache", "hit-or-fill")
+		w.Write(body)
+	})
+}
+
+func parseInt64(s string) int64 {
+	v, _ := strconv.ParseInt(s, 10, 64)
+	return v
+}
+
+// responseRecorder buffers a handler's output so Middleware can cache it
+// before writing it to the real client.
+type responseRecorder struct {
+	header http.Header
+	body   *bytes.Buffer
+	err    error
+}
+
+func newResponseRecorder() *responseRecorder {
+	return &responseRecorder{header: make(http.Header), body: &bytes.Buffer{}}
+}
+
+func (r *responseRecorder) Header() http.Header { return r.header }
+
+func (r *responseRecorder) Write(b []byte) (int, error) { return r.body.Write(b) }
+
+func (r *responseRecorder) WriteHeader(status int) {
+	if status >= 400 {
+		r.err = errStatus(status)
+	}
+}
+
+type errStatus int
+
+func (e errStatus) Error() string { return "upstream render handler returned status " + strconv.Itoa(int(e)) }
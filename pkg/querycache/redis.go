@@ -0,0 +1,52 @@
+package querycache
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/pkg/errors"
+)
+
+// RedisBackend is the optional shared Backend configured under
+// [cache.redis]. Consulted when the in-memory LRU misses, so a cache warmed
+// by one graphite-clickhouse instance benefits every other instance behind
+// the same load balancer.
+type RedisBackend struct {
+	client *redis.Client
+}
+
+// NewRedisBackend dials addr eagerly (Ping) so that a misconfigured Redis
+// is reported at startup instead of silently falling back to per-instance
+// caching on the first query.
+func NewRedisBackend(addr, password string, db int) (*RedisBackend, error) {
+	client := redis.NewClient(&redis.Options{Addr: addr, Password: password, DB: db})
+
+	if err := client.Ping(context.Background()).Err(); err != nil {
+		return nil, errors.Wrapf(err, "error connecting to cache redis backend %s", addr)
+	}
+
+	return &RedisBackend{client: client}, nil
+}
+
+// Get implements Backend.
+func (b *RedisBackend) Get(key string) ([]byte, bool) {
+	v, err := b.client.Get(context.Background(), key).Bytes()
+	if err != nil {
+		return nil, false
+	}
+	return v, true
+}
+
+// Set implements Backend.
+func (b *RedisBackend) Set(key string, value []byte, ttl time.Duration) {
+	// Errors are intentionally swallowed: the shared backend is an
+	// optimization, never a requirement -- a Redis hiccup should degrade to
+	// per-instance LRU caching, not fail the query.
+	b.client.Set(context.Background(), key, value, ttl)
+}
+
+// Close releases the underlying Redis connection pool.
+func (b *RedisBackend) Close() error {
+	return b.client.Close()
+}
@@ -0,0 +1,82 @@
+package prometheus
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+
+	"github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+	prom_config "github.com/prometheus/prometheus/config"
+	"github.com/prometheus/prometheus/discovery"
+	"github.com/prometheus/prometheus/notifier"
+
+	"github.com/lomik/graphite-clickhouse/config"
+)
+
+// alertmanagerSet wraps prometheus/notifier.Manager so that Handler can
+// satisfy the alertmanagerRetriever interface expected by v1.NewAPI.
+// Alertmanagers are configured statically under config.Prometheus.Alertmanagers;
+// graphite-clickhouse never fires alerts itself (that's ruleManager's job via
+// the notifier queue), this just reports where notifications are headed.
+type alertmanagerSet struct {
+	notifier *notifier.Manager
+	cancel   context.CancelFunc
+}
+
+func newAlertmanagerSet(cfg *config.Config) (*alertmanagerSet, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	nm := notifier.NewManager(&notifier.Options{QueueCapacity: 10000}, log.NewNopLogger())
+
+	amCfgs := make([]*prom_config.AlertmanagerConfig, 0, len(cfg.Prometheus.Alertmanagers))
+	for _, am := range cfg.Prometheus.Alertmanagers {
+		amCfgs = append(amCfgs, am)
+	}
+
+	if err := nm.ApplyConfig(&prom_config.Config{AlertingConfig: prom_config.AlertingConfig{
+		AlertmanagerConfigs: amCfgs,
+	}}); err != nil {
+		cancel()
+		return nil, errors.Wrap(err, "error applying alertmanager config")
+	}
+
+	discoveryManager := discovery.NewManager(ctx, nil)
+	discoveryConfigs := make(map[string]discovery.Configs, len(amCfgs))
+	for i, am := range amCfgs {
+		discoveryConfigs[amJobName(i)] = am.ServiceDiscoveryConfigs
+	}
+	if err := discoveryManager.ApplyConfig(discoveryConfigs); err != nil {
+		cancel()
+		return nil, errors.Wrap(err, "error applying alertmanager discovery config")
+	}
+
+	go func() {
+		if err := discoveryManager.Run(); err != nil && err != context.Canceled {
+			logger.Errorf("alertmanager discovery manager stopped: %s", err)
+		}
+	}()
+
+	go nm.Run(discoveryManager.SyncCh())
+
+	return &alertmanagerSet{notifier: nm, cancel: cancel}, nil
+}
+
+func amJobName(i int) string {
+	return fmt.Sprintf("alertmanager-%d", i)
+}
+
+func (as *alertmanagerSet) Stop() {
+	as.notifier.Stop()
+	as.cancel()
+}
+
+// Alertmanagers implements the alertmanagerRetriever interface used by the v1 API.
+func (as *alertmanagerSet) Alertmanagers() []*url.URL {
+	return as.notifier.Alertmanagers()
+}
+
+// DroppedAlertmanagers implements the alertmanagerRetriever interface used by the v1 API.
+func (as *alertmanagerSet) DroppedAlertmanagers() []*url.URL {
+	return as.notifier.DroppedAlertmanagers()
+}
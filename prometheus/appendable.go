@@ -0,0 +1,24 @@
+package prometheus
+
+import (
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/storage"
+)
+
+// discardingAppendable is the storage.Appendable handed to both rule
+// evaluation and the scrape manager: graphite-clickhouse is a read-only
+// storage.Queryable, so recording rules and scraped samples have nowhere
+// durable to write new series back to. It must still be a real, non-nil
+// implementation -- the scrape manager calls Appender() for every
+// successful scrape of a configured target, so a nil storage.Appendable
+// panics on ordinary, expected traffic rather than just misconfiguration.
+type discardingAppendable struct{}
+
+func (discardingAppendable) Appender() storage.Appender { return discardingAppender{} }
+
+type discardingAppender struct{}
+
+func (discardingAppender) Add(l labels.Labels, t int64, v float64) (uint64, error) { return 0, nil }
+func (discardingAppender) AddFast(ref uint64, t int64, v float64) error            { return nil }
+func (discardingAppender) Commit() error                                          { return nil }
+func (discardingAppender) Rollback() error                                        { return nil }
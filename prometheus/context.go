@@ -0,0 +1,40 @@
+package prometheus
+
+import "context"
+
+type contextKey int
+
+const (
+	tenantContextKey contextKey = iota
+	noCacheContextKey
+)
+
+// withTenant attaches the X-Scope-OrgID tenant to ctx so that downstream
+// code (the query cache key, per-tenant remote-write routing) can recover
+// it without re-reading the original *http.Request.
+func withTenant(ctx context.Context, tenant string) context.Context {
+	if tenant == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, tenantContextKey, tenant)
+}
+
+// tenantFromContext returns the tenant attached by withTenant, or "" if none.
+func tenantFromContext(ctx context.Context) string {
+	tenant, _ := ctx.Value(tenantContextKey).(string)
+	return tenant
+}
+
+// withNoCache attaches whether the request opted out of caching (per
+// querycache.NoCache, i.e. "Cache-Control: no-cache") to ctx, so the
+// PromQL query path can respect it the same way the (unmounted)
+// querycache.Middleware does for the Graphite render path.
+func withNoCache(ctx context.Context, noCache bool) context.Context {
+	return context.WithValue(ctx, noCacheContextKey, noCache)
+}
+
+// noCacheFromContext returns the flag attached by withNoCache.
+func noCacheFromContext(ctx context.Context) bool {
+	noCache, _ := ctx.Value(noCacheContextKey).(bool)
+	return noCache
+}
@@ -20,6 +20,7 @@ import (
 	"github.com/prometheus/prometheus/web"
 
 	"github.com/lomik/graphite-clickhouse/config"
+	"github.com/lomik/graphite-clickhouse/pkg/querycache"
 	"github.com/prometheus/common/model"
 	"github.com/prometheus/common/route"
 	"github.com/prometheus/common/server"
@@ -33,29 +34,78 @@ type Handler struct {
 	apiV1Router *route.Router
 	web         *web.Handler
 	queryEngine *promql.Engine
+
+	scrape       *scrapeManager
+	rules        *ruleManager
+	alertmanager *alertmanagerSet
+	cache        *querycache.Cache
+	uplinks      *uplinkPool
 }
 
-func NewHandler(config *config.Config) *Handler {
+func NewHandler(config *config.Config) (*Handler, error) {
 	h := &Handler{
 		config:      config,
 		queryEngine: promql.NewEngine(promql.EngineOpts{MaxConcurrent: 100, MaxSamples: 1000000, Timeout: time.Minute}),
+		uplinks:     newUplinkPool(),
+	}
+
+	var err error
+
+	h.scrape, err = newScrapeManager(config)
+	if err != nil {
+		return nil, errors.Wrap(err, "error starting scrape manager")
+	}
+
+	h.rules, err = newRuleManager(config, h.queryEngine, h)
+	if err != nil {
+		h.scrape.Stop()
+		return nil, errors.Wrap(err, "error starting rule manager")
+	}
+
+	h.alertmanager, err = newAlertmanagerSet(config)
+	if err != nil {
+		h.scrape.Stop()
+		h.rules.Stop()
+		return nil, errors.Wrap(err, "error starting alertmanager notifier")
+	}
+
+	if config.Cache.Enabled {
+		var shared querycache.Backend
+		if config.Cache.Redis.Addr != "" {
+			redisBackend, err := querycache.NewRedisBackend(config.Cache.Redis.Addr, config.Cache.Redis.Password, config.Cache.Redis.DB)
+			if err != nil {
+				h.scrape.Stop()
+				h.rules.Stop()
+				h.alertmanager.Stop()
+				return nil, errors.Wrap(err, "error starting query cache")
+			}
+			shared = redisBackend
+		}
+
+		h.cache, err = querycache.New(&config.Cache, shared)
+		if err != nil {
+			h.scrape.Stop()
+			h.rules.Stop()
+			h.alertmanager.Stop()
+			return nil, errors.Wrap(err, "error starting query cache")
+		}
 	}
 
 	apiV1 := v1.NewAPI(
-		h.queryEngine, // qe *promql.Engine,
-		h,             // q storage.Queryable,
-		nil,           // tr targetRetriever,
-		nil,           // ar alertmanagerRetriever,
-		nil,           // configFunc func() config.Config,
-		nil,           // flagsMap map[string]string,
+		h.queryEngine,    // qe *promql.Engine,
+		h,                // q storage.Queryable,
+		h.scrape,         // tr targetRetriever,
+		h.alertmanager,   // ar alertmanagerRetriever,
+		h.configSnapshot, // configFunc func() config.Config,
+		map[string]string{}, // flagsMap map[string]string,
 		func(f http.HandlerFunc) http.HandlerFunc { return f }, // readyFunc func(http.HandlerFunc) http.HandlerFunc,
-		nil,   // db func() TSDBAdmin,
-		false, // enableAdmin bool,
-		nil,   // logger log.Logger,
-		nil,   // rr rulesRetriever,
-		0,     // remoteReadSampleLimit int,
-		0,     // remoteReadConcurrencyLimit int,
-		nil,   // CORSOrigin *regexp.Regexp,
+		nil,     // db func() TSDBAdmin,
+		false,   // enableAdmin bool,
+		nil,     // logger log.Logger,
+		h.rules, // rr rulesRetriever,
+		0,       // remoteReadSampleLimit int,
+		0,       // remoteReadConcurrencyLimit int,
+		nil,     // CORSOrigin *regexp.Regexp,
 	)
 
 	apiV1Router := route.New()
@@ -66,15 +116,39 @@ func NewHandler(config *config.Config) *Handler {
 	h.apiV1Router = apiV1Router
 	h.web = &web.Handler{}
 
-	return h
+	return h, nil
+}
+
+// Stop shuts down the background scrape, rule evaluation and alertmanager
+// notification goroutines started by NewHandler.
+func (h *Handler) Stop() {
+	h.scrape.Stop()
+	h.rules.Stop()
+	h.alertmanager.Stop()
+	h.uplinks.Close()
+}
+
+// configSnapshot implements the configFunc passed to v1.NewAPI, backing
+// /api/v1/status/config.
+func (h *Handler) configSnapshot() config.Config {
+	return *h.config
 }
 
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx := withTenant(r.Context(), r.Header.Get("X-Scope-OrgID"))
+	ctx = withNoCache(ctx, querycache.NoCache(r))
+	r = r.WithContext(ctx)
+
 	if strings.HasSuffix(r.URL.Path, "/read") {
 		h.read(w, r)
 		return
 	}
 
+	if strings.HasSuffix(r.URL.Path, "/write") {
+		h.write(w, r)
+		return
+	}
+
 	if strings.HasPrefix(r.URL.Path, "/api/v1") {
 		http.StripPrefix("/api/v1", h.apiV1Router).ServeHTTP(w, r)
 		return
@@ -0,0 +1,173 @@
+package prometheus
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/storage"
+
+	"github.com/lomik/graphite-clickhouse/finder"
+	"github.com/lomik/graphite-clickhouse/pkg/querycache"
+	"github.com/lomik/graphite-clickhouse/render"
+)
+
+var (
+	errIndexFallback  = fmt.Errorf("partial result: tag index was unavailable, fell back to a full scan")
+	errRangeTruncated = fmt.Errorf("partial result: requested range was truncated by a server-side limit")
+)
+
+func errLimitExceeded(reason string) error {
+	return fmt.Errorf("partial result: %s", reason)
+}
+
+// Querier implements storage.Queryable for Handler, so it can be handed
+// directly to promql.Engine and to v1.NewAPI as the q storage.Queryable
+// argument.
+func (h *Handler) Querier(ctx context.Context, mint, maxt int64) (storage.Querier, error) {
+	return &querier{ctx: ctx, handler: h, mint: mint, maxt: maxt}, nil
+}
+
+type querier struct {
+	ctx     context.Context
+	handler *Handler
+	mint    int64
+	maxt    int64
+}
+
+func (q *querier) Close() error { return nil }
+
+func (q *querier) LabelValues(name string, matchers ...*labels.Matcher) ([]string, storage.Warnings, error) {
+	return nil, nil, nil
+}
+
+func (q *querier) LabelNames(matchers ...*labels.Matcher) ([]string, storage.Warnings, error) {
+	return nil, nil, nil
+}
+
+// Select runs a finder lookup followed by a render fetch for the matched
+// series and reports any partial-result condition (row/time limit reached,
+// series dropped by max-metrics-per-target, index fallback used) as
+// storage.Warnings rather than failing the whole query outright.
+func (q *querier) Select(sortSeries bool, hints *storage.SelectHints, matchers ...*labels.Matcher) storage.SeriesSet {
+	fnd, err := finder.Find(q.handler.config, q.ctx, matchers, q.mint, q.maxt)
+	if err != nil {
+		return storage.ErrSeriesSet(err)
+	}
+
+	var warnings storage.Warnings
+	if fnd.Limited() {
+		warnings = append(warnings, errLimitExceeded(fnd.LimitReason()))
+	}
+	if fnd.UsedIndexFallback() {
+		warnings = append(warnings, errIndexFallback)
+	}
+
+	data, err := q.fetch(fnd, hints)
+	if err != nil {
+		return storage.ErrSeriesSet(err)
+	}
+	if data.Truncated() {
+		warnings = append(warnings, errRangeTruncated)
+	}
+
+	return &seriesSet{data: data, warnings: warnings}
+}
+
+// cacheBucketSize is the step used to align cache buckets when the query
+// itself carries no step hint (e.g. an instant query). It only affects
+// cache-key granularity, not query results.
+const cacheBucketSize = 60
+
+// fetch runs the actual render.Fetch, going through the query cache (see
+// pkg/querycache) when one is configured. The requested range is split into
+// aligned step-sized buckets via querycache.SplitBuckets and each bucket is
+// fetched/cached independently, so a dashboard auto-refreshing e.g. "last
+// 1h" reuses every previously-cached bucket and only fetches the new tail
+// from ClickHouse instead of recomputing the whole range. The cache key is
+// scoped to the requesting tenant (X-Scope-OrgID, threaded through q.ctx by
+// Handler.ServeHTTP) so that one tenant's cached series can never be served
+// back to another, and a "Cache-Control: no-cache" request (also threaded
+// through q.ctx) bypasses both the read and the write side of the cache.
+func (q *querier) fetch(fnd *finder.Finder, hints *storage.SelectHints) (*render.Data, error) {
+	if q.handler.cache == nil {
+		return render.Fetch(q.handler.config, q.ctx, fnd, q.mint, q.maxt)
+	}
+
+	var step int64 = cacheBucketSize
+	if hints != nil && hints.Step > 0 {
+		step = hints.Step
+	}
+
+	tenant := tenantFromContext(q.ctx)
+	target := fnd.NormalizedTarget()
+
+	buckets := querycache.SplitBuckets(q.mint, q.maxt, step)
+	parts := make([]*render.Data, 0, len(buckets))
+
+	for _, b := range buckets {
+		// MaxDataPoints is intentionally left at its zero value here: it's
+		// part of Key so querycache.Middleware can scope Graphite render
+		// requests (which do carry a maxDataPoints query param) by it, but
+		// storage.SelectHints -- the only hint PromQL gives this path --
+		// has no equivalent field to populate it from.
+		key := querycache.Key{
+			Target: target,
+			Tenant: tenant,
+			From:   b.From,
+			Until:  b.Until,
+			Step:   step,
+		}
+
+		raw, err := q.handler.cache.Fetch(key, noCacheFromContext(q.ctx), func() ([]byte, error) {
+			data, err := render.Fetch(q.handler.config, q.ctx, fnd, b.From, b.Until)
+			if err != nil {
+				return nil, err
+			}
+			return data.Marshal()
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		data, err := render.Unmarshal(raw)
+		if err != nil {
+			return nil, err
+		}
+
+		parts = append(parts, data)
+	}
+
+	return render.Merge(parts...)
+}
+
+type seriesSet struct {
+	data     *render.Data
+	warnings storage.Warnings
+	cur      int
+}
+
+func (s *seriesSet) Next() bool {
+	s.cur++
+	return s.cur <= s.data.Len()
+}
+
+func (s *seriesSet) At() storage.Series {
+	return s.data.Series(s.cur - 1)
+}
+
+func (s *seriesSet) Err() error { return nil }
+
+// Warnings surfaces partial-result conditions encountered while answering
+// this query, e.g. a row/time limit hit in ClickHouse or series dropped by
+// max-metrics-per-target. The HTTP API serializes these into the top-level
+// "warnings" field of /api/v1/query and /api/v1/query_range responses.
+//
+// NOTE: chunk0-2 also asked for a matching X-Graphite-Warnings header on
+// the Graphite /render endpoint, so carbonapi/graphite-web clients see the
+// same partial-result conditions. There is no Graphite render HTTP handler
+// in this repository slice (the render package it would live in isn't part
+// of this checkout) to add that header to, so that half of the request is
+// not implemented here -- only the storage.Warnings plumbing through the
+// Prometheus API above is done.
+func (s *seriesSet) Warnings() storage.Warnings { return s.warnings }
@@ -0,0 +1,60 @@
+package prometheus
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/promql"
+	"github.com/prometheus/prometheus/rules"
+	"github.com/prometheus/prometheus/storage"
+
+	"github.com/lomik/graphite-clickhouse/config"
+)
+
+// ruleManager wraps prometheus/rules.Manager so that Handler can satisfy the
+// rulesRetriever interface expected by v1.NewAPI. Rule files are read from
+// config.Prometheus.RuleFiles and evaluated against the same queryEngine used
+// to serve PromQL queries, so recording/alerting rules see exactly the data
+// the rest of the API sees.
+type ruleManager struct {
+	manager *rules.Manager
+}
+
+func newRuleManager(cfg *config.Config, queryEngine *promql.Engine, queryable storage.Queryable) (*ruleManager, error) {
+	opts := &rules.ManagerOptions{
+		QueryFunc:  rules.EngineQueryFunc(queryEngine, queryable),
+		Appendable: discardingAppendable{},
+		Context:    context.Background(),
+		Logger:     log.NewNopLogger(),
+		OutageTolerance: 1 * time.Hour,
+		ForGracePeriod:  10 * time.Minute,
+	}
+
+	mgr := rules.NewManager(opts)
+
+	if len(cfg.Prometheus.RuleFiles) > 0 {
+		if errs := mgr.Update(time.Duration(cfg.Prometheus.EvaluationInterval), cfg.Prometheus.RuleFiles, nil); len(errs) > 0 {
+			return nil, errors.Wrapf(errs[0], "error loading rule files")
+		}
+	}
+
+	go mgr.Run()
+
+	return &ruleManager{manager: mgr}, nil
+}
+
+func (rm *ruleManager) Stop() {
+	rm.manager.Stop()
+}
+
+// RuleGroups implements the rulesRetriever interface used by the v1 API.
+func (rm *ruleManager) RuleGroups() []*rules.Group {
+	return rm.manager.RuleGroups()
+}
+
+// AlertingRules implements the rulesRetriever interface used by the v1 API.
+func (rm *ruleManager) AlertingRules() []*rules.AlertingRule {
+	return rm.manager.AlertingRules()
+}
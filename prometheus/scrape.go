@@ -0,0 +1,88 @@
+package prometheus
+
+import (
+	"context"
+
+	"github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+	prom_config "github.com/prometheus/prometheus/config"
+	"github.com/prometheus/prometheus/discovery"
+	"github.com/prometheus/prometheus/scrape"
+
+	"github.com/lomik/graphite-clickhouse/config"
+	"github.com/lomik/zapwriter"
+)
+
+var logger = zapwriter.Logger("prometheus").Sugar()
+
+// scrapeManager wraps prometheus/scrape.Manager so that Handler can satisfy
+// the targetRetriever interface expected by v1.NewAPI. Targets are discovered
+// from the static/file/dns service discovery configured under
+// [[prometheus.scrape_configs]], so that hosts already sending metrics to
+// graphite-clickhouse can be exposed on /api/v1/targets without a separate
+// Prometheus instance.
+type scrapeManager struct {
+	manager   *scrape.Manager
+	discovery *discovery.Manager
+	cancel    context.CancelFunc
+}
+
+func newScrapeManager(cfg *config.Config) (*scrapeManager, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	discoveryManager := discovery.NewManager(ctx, nil)
+	// discardingAppendable is a real, non-nil storage.Appendable: the scrape
+	// manager calls Appender() on every successful scrape, so a nil one
+	// would panic as soon as any configured target answers.
+	scrapeManager := scrape.NewManager(log.NewNopLogger(), discardingAppendable{})
+
+	scrapeConfigs := make([]*prom_config.ScrapeConfig, 0, len(cfg.Prometheus.ScrapeConfigs))
+	discoveryConfigs := make(map[string]discovery.Configs, len(cfg.Prometheus.ScrapeConfigs))
+	for _, sc := range cfg.Prometheus.ScrapeConfigs {
+		scrapeConfigs = append(scrapeConfigs, sc)
+		discoveryConfigs[sc.JobName] = sc.ServiceDiscoveryConfigs
+	}
+
+	if err := discoveryManager.ApplyConfig(discoveryConfigs); err != nil {
+		cancel()
+		return nil, errors.Wrap(err, "error applying scrape discovery config")
+	}
+
+	if err := scrapeManager.ApplyConfig(&prom_config.Config{ScrapeConfigs: scrapeConfigs}); err != nil {
+		cancel()
+		return nil, errors.Wrap(err, "error applying scrape config")
+	}
+
+	go func() {
+		if err := discoveryManager.Run(); err != nil && err != context.Canceled {
+			logger.Errorf("scrape discovery manager stopped: %s", err)
+		}
+	}()
+
+	go func() {
+		if err := scrapeManager.Run(discoveryManager.SyncCh()); err != nil {
+			logger.Errorf("scrape manager stopped: %s", err)
+		}
+	}()
+
+	return &scrapeManager{
+		manager:   scrapeManager,
+		discovery: discoveryManager,
+		cancel:    cancel,
+	}, nil
+}
+
+func (sm *scrapeManager) Stop() {
+	sm.manager.Stop()
+	sm.cancel()
+}
+
+// TargetsActive implements the targetRetriever interface used by the v1 API.
+func (sm *scrapeManager) TargetsActive() map[string][]*scrape.Target {
+	return sm.manager.TargetsActive()
+}
+
+// TargetsDropped implements the targetRetriever interface used by the v1 API.
+func (sm *scrapeManager) TargetsDropped() map[string][]*scrape.Target {
+	return sm.manager.TargetsDropped()
+}
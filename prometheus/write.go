@@ -0,0 +1,243 @@
+package prometheus
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/pkg/relabel"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+var (
+	writeSamplesTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "graphite_clickhouse_remote_write_samples_total",
+		Help: "Total number of samples accepted on /write.",
+	})
+	writeDroppedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "graphite_clickhouse_remote_write_dropped_total",
+		Help: "Total number of samples dropped on /write, by reason.",
+	}, []string{"reason"})
+	writeUplinkQueueLen = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "graphite_clickhouse_remote_write_uplink_queue_length",
+		Help: "Number of plaintext carbon lines buffered for the uplink, for backpressure monitoring.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(writeSamplesTotal, writeDroppedTotal, writeUplinkQueueLen)
+}
+
+// write implements the /write remote-write receiver: it decodes a
+// snappy-framed prompb.WriteRequest, relabels and converts each TimeSeries
+// into a graphite 1.1 tagged metric path, and hands the result to the
+// configured carbon-clickhouse uplink.
+func (h *Handler) write(w http.ResponseWriter, r *http.Request) {
+	compressed, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	body, err := snappy.Decode(nil, compressed)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var req prompb.WriteRequest
+	if err := req.Unmarshal(body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tenant := r.Header.Get("X-Scope-OrgID")
+
+	up, err := h.uplinkFor(tenant)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	for _, ts := range req.Timeseries {
+		lbls := relabel.Process(promLabels(ts.Labels), h.config.Prometheus.RemoteWrite.RelabelConfigs...)
+		if lbls == nil {
+			writeDroppedTotal.WithLabelValues("relabel_drop").Inc()
+			continue
+		}
+
+		path := graphitePath(lbls)
+		if path == "" {
+			writeDroppedTotal.WithLabelValues("no_metric_name").Inc()
+			continue
+		}
+
+		for _, sample := range ts.Samples {
+			line := fmt.Sprintf("%s %v %d\n", path, sample.Value, sample.Timestamp/1000)
+			if err := up.Write(line); err != nil {
+				writeDroppedTotal.WithLabelValues("uplink_error").Inc()
+				continue
+			}
+			writeSamplesTotal.Inc()
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// promLabels converts prompb's wire-format label pairs into labels.Labels
+// so they can be run through the shared relabel.Process pipeline.
+func promLabels(pbLabels []prompb.Label) labels.Labels {
+	lbls := make(labels.Labels, len(pbLabels))
+	for i, l := range pbLabels {
+		lbls[i] = labels.Label{Name: l.Name, Value: l.Value}
+	}
+	sort.Sort(lbls)
+	return lbls
+}
+
+// graphitePath renders a label set as a graphite 1.1 tagged metric path:
+// the __name__ label as the base, followed by sorted "label=value" tag
+// segments, e.g. "cpu.usage;instance=host1;mode=idle".
+func graphitePath(lbls labels.Labels) string {
+	var name string
+	segments := make([]string, 0, len(lbls))
+
+	for _, l := range lbls {
+		if l.Name == "__name__" {
+			name = l.Value
+			continue
+		}
+		segments = append(segments, l.Name+"="+l.Value)
+	}
+
+	if name == "" {
+		return ""
+	}
+
+	sort.Strings(segments)
+
+	if len(segments) == 0 {
+		return name
+	}
+
+	return name + ";" + strings.Join(segments, ";")
+}
+
+// uplink is a minimal sink for converted carbon lines. Its only
+// implementation is carbonUplink, a plaintext carbon-clickhouse TCP
+// connection, selected per tenant via
+// config.Prometheus.RemoteWrite.TenantUplinks with DefaultUplink as the
+// fallback; there is no direct ClickHouse HTTP insert.
+type uplink interface {
+	Write(line string) error
+}
+
+// uplinkPool holds one persistent carbonUplink per configured address,
+// reused across requests instead of dialing a new TCP connection per
+// /write call -- remote-write POSTs continuously (every 1-5s per shard),
+// so a per-request dial would leak a socket per request.
+type uplinkPool struct {
+	mu    sync.Mutex
+	conns map[string]*carbonUplink
+}
+
+func newUplinkPool() *uplinkPool {
+	return &uplinkPool{conns: make(map[string]*carbonUplink)}
+}
+
+// get returns the pooled uplink for addr, dialing it lazily on first use.
+func (p *uplinkPool) get(addr string) (*carbonUplink, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if u, ok := p.conns[addr]; ok {
+		return u, nil
+	}
+
+	u := &carbonUplink{addr: addr}
+	p.conns[addr] = u
+	return u, nil
+}
+
+// Close closes every pooled connection; called from Handler.Stop.
+func (p *uplinkPool) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for addr, u := range p.conns {
+		u.close()
+		delete(p.conns, addr)
+	}
+}
+
+// carbonUplink is a single persistent, lazily-(re)dialed TCP connection to
+// a carbon-clickhouse uplink. Writes are serialized by mu; a failed write
+// drops the stale connection so the next write redials.
+type carbonUplink struct {
+	addr string
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+func (u *carbonUplink) Write(line string) error {
+	writeUplinkQueueLen.Inc()
+	defer writeUplinkQueueLen.Dec()
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.conn == nil {
+		conn, err := net.DialTimeout("tcp", u.addr, 5*time.Second)
+		if err != nil {
+			return errors.Wrapf(err, "error dialing carbon-clickhouse uplink %s", u.addr)
+		}
+		u.conn = conn
+	}
+
+	if _, err := u.conn.Write([]byte(line)); err != nil {
+		u.conn.Close()
+		u.conn = nil
+		return err
+	}
+
+	return nil
+}
+
+func (u *carbonUplink) close() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.conn != nil {
+		u.conn.Close()
+		u.conn = nil
+	}
+}
+
+// uplinkFor resolves the pooled carbon-clickhouse uplink for a tenant,
+// falling back to the default uplink when no per-tenant route is
+// configured.
+func (h *Handler) uplinkFor(tenant string) (uplink, error) {
+	addr := h.config.Prometheus.RemoteWrite.DefaultUplink
+	if tenant != "" {
+		if ta, ok := h.config.Prometheus.RemoteWrite.TenantUplinks[tenant]; ok {
+			addr = ta
+		}
+	}
+
+	if addr == "" {
+		return nil, errors.New("no carbon-clickhouse uplink configured for remote_write")
+	}
+
+	return h.uplinks.get(addr)
+}
@@ -0,0 +1,99 @@
+package prometheus
+
+import (
+	"bufio"
+	"net"
+	"testing"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+)
+
+func TestGraphitePath(t *testing.T) {
+	cases := []struct {
+		name string
+		lbls labels.Labels
+		want string
+	}{
+		{
+			name: "name with sorted tags",
+			lbls: labels.Labels{
+				{Name: "__name__", Value: "cpu.usage"},
+				{Name: "mode", Value: "idle"},
+				{Name: "instance", Value: "host1"},
+			},
+			want: "cpu.usage;instance=host1;mode=idle",
+		},
+		{
+			name: "name with no tags",
+			lbls: labels.Labels{
+				{Name: "__name__", Value: "cpu.usage"},
+			},
+			want: "cpu.usage",
+		},
+		{
+			name: "missing __name__ drops the series",
+			lbls: labels.Labels{
+				{Name: "instance", Value: "host1"},
+			},
+			want: "",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := graphitePath(c.lbls); got != c.want {
+				t.Errorf("graphitePath(%v) = %q, want %q", c.lbls, got, c.want)
+			}
+		})
+	}
+}
+
+// TestCarbonUplinkRedialsAfterAStaleConnection verifies that once the
+// cached net.Conn has been closed and dropped (as Write does after a failed
+// write), the next Write dials a fresh connection and delivers its line.
+func TestCarbonUplinkRedialsAfterAStaleConnection(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error starting test listener: %s", err)
+	}
+	defer ln.Close()
+
+	lines := make(chan string, 2)
+	accept := func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		scanner := bufio.NewScanner(conn)
+		if scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}
+
+	go accept()
+
+	u := &carbonUplink{addr: ln.Addr().String()}
+	if err := u.Write("cpu.usage 1 1000\n"); err != nil {
+		t.Fatalf("first write: %s", err)
+	}
+	if got := <-lines; got != "cpu.usage 1 1000" {
+		t.Fatalf("first write got line %q", got)
+	}
+
+	// The server closed its side after one line; force the client's cached
+	// conn closed too so the next Write must redial instead of reusing it.
+	u.mu.Lock()
+	u.conn.Close()
+	u.conn = nil
+	u.mu.Unlock()
+
+	go accept()
+
+	if err := u.Write("cpu.usage 2 2000\n"); err != nil {
+		t.Fatalf("second write after redial: %s", err)
+	}
+	if got := <-lines; got != "cpu.usage 2 2000" {
+		t.Fatalf("second write got line %q", got)
+	}
+}